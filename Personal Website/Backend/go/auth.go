@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// identityContextKey is the context key under which authMiddleware stores
+// the authenticated identity, for instrumentedLoggingMiddleware to record.
+type identityContextKey struct{}
+
+// Auther authenticates an incoming request, returning the identity it
+// resolved to and whether authentication succeeded.
+type Auther interface {
+	Authenticate(r *http.Request) (identity string, ok bool)
+}
+
+// basicAuther authenticates against an htpasswd-style file of
+// "user:hash" lines, where hash is a bcrypt ($2a$/$2b$/$2y$) or argon2id
+// ($argon2id$...) PHC-format hash.
+type basicAuther struct {
+	credentials map[string]string // user -> hash
+}
+
+func newBasicAuther(path string) (*basicAuther, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open auth-basic-file: %w", err)
+	}
+	defer f.Close()
+
+	credentials := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("malformed auth-basic-file line: %q", line)
+		}
+		credentials[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read auth-basic-file: %w", err)
+	}
+	return &basicAuther{credentials: credentials}, nil
+}
+
+func (a *basicAuther) Authenticate(r *http.Request) (string, bool) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	hash, found := a.credentials[user]
+	if !found {
+		return "", false
+	}
+	if !verifyPassword(hash, pass) {
+		return "", false
+	}
+	return user, true
+}
+
+// verifyPassword checks password against hash, which is either a bcrypt
+// hash or an argon2id PHC string ($argon2id$v=19$m=...,t=...,p=...$salt$hash).
+func verifyPassword(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return verifyArgon2id(hash, password)
+	default:
+		return false
+	}
+}
+
+func verifyArgon2id(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	// ["", "argon2id", "v=19", "m=65536,t=3,p=2", "<salt>", "<hash>"]
+	if len(parts) != 6 {
+		return false
+	}
+	var memory, time uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &parallelism); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+	got := argon2.IDKey([]byte(password), salt, time, memory, parallelism, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// bearerAuther authenticates against a file of "identity:token" lines.
+type bearerAuther struct {
+	tokens map[string]string // token -> identity
+}
+
+func newBearerAuther(path string) (*bearerAuther, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open auth-bearer-file: %w", err)
+	}
+	defer f.Close()
+
+	tokens := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		identity, token, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("malformed auth-bearer-file line: %q", line)
+		}
+		tokens[token] = identity
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read auth-bearer-file: %w", err)
+	}
+	return &bearerAuther{tokens: tokens}, nil
+}
+
+func (a *bearerAuther) Authenticate(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if !strings.HasPrefix(auth, prefix) {
+		return "", false
+	}
+	token := strings.TrimPrefix(auth, prefix)
+	for known, identity := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			return identity, true
+		}
+	}
+	return "", false
+}
+
+// mtlsAuther authenticates requests that presented a client certificate
+// verified against the server's configured ClientCAs; the identity is the
+// certificate's subject common name.
+type mtlsAuther struct{}
+
+func (mtlsAuther) Authenticate(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", false
+	}
+	return r.TLS.PeerCertificates[0].Subject.CommonName, true
+}
+
+// loadClientCAPool reads a PEM file of trusted client CA certificates for
+// -auth-client-ca.
+func loadClientCAPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("open auth-client-ca: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in auth-client-ca file %q", path)
+	}
+	return pool, nil
+}
+
+// authMiddleware gates the configured protectedPrefixes behind authers,
+// trying each in turn and accepting the first that succeeds. Requests
+// outside protectedPrefixes pass through untouched, so /healthz and
+// /static/ stay public unless explicitly listed in -auth-protect.
+func authMiddleware(next http.Handler, authers []Auther, protectedPrefixes []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAuthProtected(r.URL.Path, protectedPrefixes) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, a := range authers {
+			identity, ok := a.Authenticate(r)
+			if !ok {
+				continue
+			}
+			ctx := context.WithValue(r.Context(), identityContextKey{}, identity)
+			*r = *r.WithContext(ctx)
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="restricted"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+func isAuthProtected(path string, protectedPrefixes []string) bool {
+	for _, prefix := range protectedPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// identityFromContext returns the identity authMiddleware recorded for r,
+// if any.
+func identityFromContext(r *http.Request) string {
+	identity, _ := r.Context().Value(identityContextKey{}).(string)
+	return identity
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2idPHC builds a PHC-format argon2id hash string for password, in the
+// same shape verifyArgon2id parses: $argon2id$v=19$m=...,t=...,p=...$salt$hash.
+func argon2idPHC(t *testing.T, password string, salt []byte, memory, time uint32, parallelism uint8, keyLen uint32) string {
+	t.Helper()
+	hash := argon2.IDKey([]byte(password), salt, time, memory, parallelism, keyLen)
+	return fmt.Sprintf("$argon2id$v=19$m=%d,t=%d,p=%d$%s$%s",
+		memory, time, parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash))
+}
+
+func TestVerifyArgon2id(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	hash := argon2idPHC(t, "correct horse", salt, 65536, 3, 2, 32)
+
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		want     bool
+	}{
+		{name: "correct password", hash: hash, password: "correct horse", want: true},
+		{name: "wrong password", hash: hash, password: "wrong password", want: false},
+		{name: "truncated hash", hash: "$argon2id$v=19$m=65536,t=3,p=2$onlyfourparts", password: "correct horse", want: false},
+		{name: "malformed params", hash: "$argon2id$v=19$not-kv-pairs$" + base64.RawStdEncoding.EncodeToString(salt) + "$deadbeef", password: "correct horse", want: false},
+		{name: "invalid salt encoding", hash: "$argon2id$v=19$m=65536,t=3,p=2$not base64!$deadbeef", password: "correct horse", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyArgon2id(tt.hash, tt.password); got != tt.want {
+				t.Errorf("verifyArgon2id(%q, %q) = %v, want %v", tt.hash, tt.password, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// PushAssets uses HTTP/2 server push, when the ResponseWriter supports it,
+// to proactively send assets alongside the response that requested them.
+// The SPA index handler calls this with its JS/CSS bundle on first
+// navigation so the browser doesn't have to round-trip for them.
+func PushAssets(w http.ResponseWriter, r *http.Request, assets []string) {
+	pusher, ok := w.(http.Pusher)
+	if !ok {
+		return
+	}
+	for _, asset := range assets {
+		if err := pusher.Push(asset, nil); err != nil {
+			log.Printf("http2 push of %s failed: %v", asset, err)
+			return
+		}
+	}
+}
+
+// h2cHandler wraps handler so it also accepts cleartext HTTP/2, for
+// deployments that sit behind a load balancer which already terminates TLS
+// and talks h2c to the origin.
+func h2cHandler(handler http.Handler, h2s *http2.Server) http.Handler {
+	return h2c.NewHandler(handler, h2s)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// requestIDHeader is the header clients can set to propagate a request ID
+// through to the access log; it's generated when absent.
+const requestIDHeader = "X-Request-Id"
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Total number of HTTP requests handled, by method, status code, and route.",
+	}, []string{"method", "code", "route"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "code", "route"})
+
+	httpRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "Number of HTTP requests currently being served.",
+	})
+
+	httpResponseSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_response_size_bytes",
+		Help:    "Size in bytes of HTTP responses.",
+		Buckets: prometheus.ExponentialBuckets(100, 10, 7),
+	}, []string{"method", "code", "route"})
+)
+
+// accessLogFields is what gets logged per request, either as JSON (one
+// object per line) or as the pre-existing space-separated text format.
+type accessLogFields struct {
+	Timestamp  string `json:"ts"`
+	Remote     string `json:"remote"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Status     int    `json:"status"`
+	Bytes      int    `json:"bytes"`
+	DurationMS int64  `json:"duration_ms"`
+	Referer    string `json:"referer"`
+	UserAgent  string `json:"ua"`
+	RequestID  string `json:"request_id"`
+	Identity   string `json:"identity,omitempty"`
+}
+
+func logAccess(format string, f accessLogFields) {
+	if format == "json" {
+		b, err := json.Marshal(f)
+		if err != nil {
+			log.Printf("failed to marshal access log: %v", err)
+			return
+		}
+		log.Println(string(b))
+		return
+	}
+	identity := f.Identity
+	if identity == "" {
+		identity = "-"
+	}
+	log.Printf("%s %s %s %d %dB %dms req=%s identity=%s",
+		f.Remote, f.Method, f.Path, f.Status, f.Bytes, f.DurationMS, f.RequestID, identity)
+}
+
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// routeLabel buckets a request path into a low-cardinality route label for
+// metrics, mirroring the mux's own routing precedence (/healthz, /static/,
+// configured proxy prefixes, then the SPA catch-all).
+func routeLabel(path string, proxyPrefixes []string) string {
+	switch {
+	case path == "/healthz":
+		return "/healthz"
+	case strings.HasPrefix(path, "/static/"):
+		return "/static/"
+	case path == "/metrics":
+		return "/metrics"
+	}
+	for _, prefix := range proxyPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return prefix
+		}
+	}
+	return "/"
+}
+
+// instrumentedLoggingMiddleware replaces the ad-hoc log.Printf logger with
+// a pluggable one (-log-format=json|text), instruments every request with
+// Prometheus metrics, and stamps an X-Request-Id header that is generated
+// when the client doesn't supply one.
+func instrumentedLoggingMiddleware(next http.Handler, logFormat string, proxyPrefixes []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		reqID := r.Header.Get(requestIDHeader)
+		if reqID == "" {
+			reqID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, reqID)
+
+		httpRequestsInFlight.Inc()
+		defer httpRequestsInFlight.Dec()
+
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r)
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+
+		duration := time.Since(start)
+		route := routeLabel(r.URL.Path, proxyPrefixes)
+		code := strconv.Itoa(lw.status)
+
+		httpRequestsTotal.WithLabelValues(r.Method, code, route).Inc()
+		httpRequestDuration.WithLabelValues(r.Method, code, route).Observe(duration.Seconds())
+		httpResponseSize.WithLabelValues(r.Method, code, route).Observe(float64(lw.size))
+
+		logAccess(logFormat, accessLogFields{
+			Timestamp:  start.UTC().Format(time.RFC3339),
+			Remote:     r.RemoteAddr,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     lw.status,
+			Bytes:      lw.size,
+			DurationMS: duration.Milliseconds(),
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+			RequestID:  reqID,
+			Identity:   identityFromContext(r),
+		})
+	})
+}
+
+// newMetricsServer builds the http.Server that exposes promhttp.Handler()
+// on its own bind address, so /metrics can be moved off the public listener
+// (e.g. to loopback) via -metrics-bind.
+func newMetricsServer(bind string) *http.Server {
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", promhttp.Handler())
+	return &http.Server{
+		Addr:    bind,
+		Handler: metricsMux,
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestRouteLabel(t *testing.T) {
+	proxyPrefixes := []string{"/api/", "/widgets/"}
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "healthz", path: "/healthz", want: "/healthz"},
+		{name: "static asset", path: "/static/app.js", want: "/static/"},
+		{name: "metrics", path: "/metrics", want: "/metrics"},
+		{name: "proxy prefix", path: "/api/widgets/1", want: "/api/"},
+		{name: "second proxy prefix", path: "/widgets/1", want: "/widgets/"},
+		{name: "spa catch-all", path: "/about", want: "/"},
+		{name: "root", path: "/", want: "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := routeLabel(tt.path, proxyPrefixes); got != tt.want {
+				t.Errorf("routeLabel(%q, %v) = %q, want %q", tt.path, proxyPrefixes, got, tt.want)
+			}
+		})
+	}
+}
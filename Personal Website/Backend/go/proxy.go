@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// proxyRoute describes a single `-proxy` mapping of the form
+// "/api/=http://localhost:9000[,opt=value,...]".
+//
+// Supported options:
+//
+//	strip-prefix=true|false   strip the mount prefix before forwarding (default true)
+//	preserve-host=true|false  forward the original Host header instead of the backend's (default false)
+//	timeout=<duration>        per-request timeout, e.g. "30s" (default 0 = no timeout)
+type proxyRoute struct {
+	prefix       string
+	target       *url.URL
+	stripPrefix  bool
+	preserveHost bool
+	timeout      time.Duration
+}
+
+// proxyFlags collects repeated `-proxy` flag values. flag.Value is
+// implemented so the flag can be passed more than once on the command line.
+type proxyFlags []string
+
+func (p *proxyFlags) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *proxyFlags) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// parseProxyRoute parses one `-proxy` flag value into a proxyRoute.
+func parseProxyRoute(raw string) (proxyRoute, error) {
+	parts := strings.Split(raw, ",")
+	mapping := parts[0]
+	eq := strings.SplitN(mapping, "=", 2)
+	if len(eq) != 2 {
+		return proxyRoute{}, fmt.Errorf("invalid -proxy mapping %q: want /prefix/=http://host:port", raw)
+	}
+	prefix := eq[0]
+	if !strings.HasPrefix(prefix, "/") {
+		return proxyRoute{}, fmt.Errorf("invalid -proxy prefix %q: must start with /", prefix)
+	}
+	target, err := url.Parse(eq[1])
+	if err != nil {
+		return proxyRoute{}, fmt.Errorf("invalid -proxy target %q: %w", eq[1], err)
+	}
+
+	route := proxyRoute{
+		prefix:      prefix,
+		target:      target,
+		stripPrefix: true,
+	}
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return proxyRoute{}, fmt.Errorf("invalid -proxy option %q in %q", opt, raw)
+		}
+		key, val := kv[0], kv[1]
+		switch key {
+		case "strip-prefix":
+			route.stripPrefix = val == "true"
+		case "preserve-host":
+			route.preserveHost = val == "true"
+		case "timeout":
+			d, err := time.ParseDuration(val)
+			if err != nil {
+				return proxyRoute{}, fmt.Errorf("invalid -proxy timeout %q: %w", val, err)
+			}
+			route.timeout = d
+		default:
+			return proxyRoute{}, fmt.Errorf("unknown -proxy option %q in %q", key, raw)
+		}
+	}
+	return route, nil
+}
+
+// stripRoutePrefix removes route.prefix from p, the way http.StripPrefix
+// does for /static/, leaving at least "/".
+func stripRoutePrefix(route proxyRoute, p string) string {
+	p = strings.TrimPrefix(p, strings.TrimSuffix(route.prefix, "/"))
+	if p == "" {
+		p = "/"
+	}
+	return p
+}
+
+// newReverseProxyHandler builds the http.Handler mounted at route.prefix.
+// Plain requests are proxied via httputil.ReverseProxy; WebSocket upgrade
+// requests are hijacked and piped directly to the backend instead, since a
+// vanilla ReverseProxy doesn't pass through the long-lived duplex
+// connection a websocket needs.
+func newReverseProxyHandler(route proxyRoute) http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(route.target)
+
+	baseDirector := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		baseDirector(r)
+		if route.stripPrefix {
+			r.URL.Path = stripRoutePrefix(route, r.URL.Path)
+		}
+		if !route.preserveHost {
+			r.Host = route.target.Host
+		}
+	}
+
+	if route.timeout > 0 {
+		proxy.Transport = &http.Transport{
+			ResponseHeaderTimeout: route.timeout,
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isWebsocketUpgrade(r) {
+			proxyWebsocket(w, r, route)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+func isWebsocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+// proxyWebsocket dials the backend, forwards the original upgrade request,
+// then hijacks the client connection and pipes bytes in both directions
+// until either side closes. It hijacks first, via http.NewResponseController
+// (the mux wraps w in *loggingResponseWriter, which isn't itself a
+// http.Hijacker but does expose one through Unwrap), since once hijacked we
+// can no longer use http.Error and must report failures straight to the
+// connection instead.
+func proxyWebsocket(w http.ResponseWriter, r *http.Request, route proxyRoute) {
+	clientConn, clientBuf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		if errors.Is(err, http.ErrNotSupported) {
+			http.Error(w, "websocket upgrade not supported", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("websocket hijack failed: %v", err)
+		return
+	}
+	defer clientConn.Close()
+
+	backendAddr := route.target.Host
+	if !strings.Contains(backendAddr, ":") {
+		if route.target.Scheme == "https" || route.target.Scheme == "wss" {
+			backendAddr += ":443"
+		} else {
+			backendAddr += ":80"
+		}
+	}
+	backendConn, err := net.DialTimeout("tcp", backendAddr, 10*time.Second)
+	if err != nil {
+		writeHijackedError(clientConn, http.StatusBadGateway, "failed to reach backend")
+		return
+	}
+	defer backendConn.Close()
+
+	if route.stripPrefix {
+		r.URL.Path = stripRoutePrefix(route, r.URL.Path)
+	}
+	if !route.preserveHost {
+		r.Host = route.target.Host
+	}
+	if err := r.Write(backendConn); err != nil {
+		writeHijackedError(clientConn, http.StatusBadGateway, "failed to forward upgrade request")
+		return
+	}
+
+	errc := make(chan error, 2)
+	go proxyCopy(errc, backendConn, clientBuf.Reader)
+	go proxyCopy(errc, clientConn, bufio.NewReader(backendConn))
+	<-errc
+}
+
+// writeHijackedError writes a minimal HTTP error response directly to conn,
+// for failures that happen after the client connection has already been
+// hijacked, when http.Error is no longer usable.
+func writeHijackedError(conn net.Conn, status int, msg string) {
+	fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nConnection: close\r\n\r\n%s", status, http.StatusText(status), msg)
+}
+
+func proxyCopy(errc chan<- error, dst net.Conn, src *bufio.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				errc <- werr
+				return
+			}
+		}
+		if err != nil {
+			errc <- err
+			return
+		}
+	}
+}
+
+// registerProxyRoutes mounts one reverse-proxy handler per route on mux.
+// ServeMux's longest-prefix-match rule means these automatically take
+// precedence over the "/" SPA fallback while never shadowing /healthz or
+// /static/, as long as no route is mounted at those exact paths.
+func registerProxyRoutes(mux *http.ServeMux, routes []proxyRoute) {
+	for _, route := range routes {
+		log.Printf("proxying %s -> %s", route.prefix, route.target)
+		mux.Handle(route.prefix, newReverseProxyHandler(route))
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseProxyRoute(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    proxyRoute
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			raw:  "/api/=http://localhost:9000",
+			want: proxyRoute{prefix: "/api/", stripPrefix: true},
+		},
+		{
+			name: "all options",
+			raw:  "/api/=http://localhost:9000,strip-prefix=false,preserve-host=true,timeout=5s",
+			want: proxyRoute{prefix: "/api/", stripPrefix: false, preserveHost: true, timeout: 5 * time.Second},
+		},
+		{
+			name:    "missing equals",
+			raw:     "/api/http://localhost:9000",
+			wantErr: true,
+		},
+		{
+			name:    "prefix without leading slash",
+			raw:     "api/=http://localhost:9000",
+			wantErr: true,
+		},
+		{
+			name:    "unknown option",
+			raw:     "/api/=http://localhost:9000,bogus=true",
+			wantErr: true,
+		},
+		{
+			name:    "invalid timeout",
+			raw:     "/api/=http://localhost:9000,timeout=notaduration",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProxyRoute(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseProxyRoute(%q) = nil error, want error", tt.raw)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseProxyRoute(%q) unexpected error: %v", tt.raw, err)
+			}
+			if got.prefix != tt.want.prefix || got.stripPrefix != tt.want.stripPrefix ||
+				got.preserveHost != tt.want.preserveHost || got.timeout != tt.want.timeout {
+				t.Errorf("parseProxyRoute(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripRoutePrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		path   string
+		want   string
+	}{
+		{name: "mount root", prefix: "/api/", path: "/api/", want: "/"},
+		{name: "nested path", prefix: "/api/", path: "/api/widgets/1", want: "/widgets/1"},
+		{name: "no trailing slash in path", prefix: "/api/", path: "/api", want: "/"},
+		{name: "unrelated path left alone", prefix: "/api/", path: "/other", want: "/other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := proxyRoute{prefix: tt.prefix}
+			if got := stripRoutePrefix(route, tt.path); got != tt.want {
+				t.Errorf("stripRoutePrefix(%q, %q) = %q, want %q", tt.prefix, tt.path, got, tt.want)
+			}
+		})
+	}
+}
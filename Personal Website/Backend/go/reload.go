@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+const (
+	// reloadEnvVar marks a process as a child spawned by runSupervisor
+	// rather than a directly-invoked server.
+	reloadEnvVar = "GUMBEE_IS_CHILD"
+	listenerFD   = 3
+	readyFD      = 4
+)
+
+// isReloadChild reports whether this process was exec'd by runSupervisor
+// and should serve from the inherited listeners instead of binding its own.
+func isReloadChild() bool {
+	return os.Getenv(reloadEnvVar) == "1"
+}
+
+// reloadFDLayout computes the fixed ExtraFiles fd assignment shared by the
+// supervisor (when building a child's ExtraFiles) and the child (when
+// looking up its inherited listeners). Both sides derive it from the same
+// -http-redirect/-metrics-bind flag values, which are identical on both
+// sides since the child re-execs with the parent's original argv, so the
+// layout never needs to travel out-of-band. redirectFD/metricsFD are 0
+// when that listener isn't enabled.
+func reloadFDLayout(httpRedirect bool, metricsBind string) (redirectFD, metricsFD int) {
+	next := 5
+	if httpRedirect {
+		redirectFD = next
+		next++
+	}
+	if metricsBind != "" {
+		metricsFD = next
+		next++
+	}
+	return redirectFD, metricsFD
+}
+
+// inheritedListenerAt reconstructs the net.Listener passed down at fd by
+// the supervisor via ExtraFiles.
+func inheritedListenerAt(fd int) (net.Listener, error) {
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("inherited-listener-fd%d", fd))
+	return net.FileListener(f)
+}
+
+// inheritedListener reconstructs the main net.Listener the supervisor
+// created and passed down via ExtraFiles at listenerFD.
+func inheritedListener() (net.Listener, error) {
+	return inheritedListenerAt(listenerFD)
+}
+
+// signalReady tells the supervisor that this child is ready to accept
+// connections, via the readiness pipe passed down at readyFD.
+func signalReady() {
+	f := os.NewFile(uintptr(readyFD), "ready-pipe")
+	if f == nil {
+		return
+	}
+	defer f.Close()
+	_, _ = f.Write([]byte("ready\n"))
+}
+
+// runSupervisor implements zero-downtime reload for -reload: it owns every
+// listening socket the server binds (the main listener, and the -http-
+// redirect and -metrics-bind listeners when enabled) and never serves HTTP
+// itself. On SIGHUP it execs a fresh child sharing all of those sockets,
+// waits for the child to report readiness, then SIGTERMs the previous
+// child so it can drain in-flight connections through its own graceful
+// shutdown. Because every child gets the same underlying sockets (dup'd
+// fds, not fresh binds), old and new children can hold them open at the
+// same time without an "address already in use" race. On SIGINT/SIGTERM
+// the supervisor forwards the signal to its current child and exits once
+// it has stopped.
+func runSupervisor(mainAddr string, httpRedirect bool, metricsBind string) error {
+	mainFile, closeMain, err := listenFile("tcp", mainAddr)
+	if err != nil {
+		return fmt.Errorf("supervisor: %w", err)
+	}
+	defer closeMain()
+
+	var redirectFile, metricsFile *os.File
+	if httpRedirect {
+		f, closeFn, err := listenFile("tcp", ":80")
+		if err != nil {
+			return fmt.Errorf("supervisor: %w", err)
+		}
+		defer closeFn()
+		redirectFile = f
+	}
+	if metricsBind != "" {
+		f, closeFn, err := listenFile("tcp", metricsBind)
+		if err != nil {
+			return fmt.Errorf("supervisor: %w", err)
+		}
+		defer closeFn()
+		metricsFile = f
+	}
+
+	child, err := spawnChild(mainFile, redirectFile, metricsFile)
+	if err != nil {
+		return fmt.Errorf("supervisor: spawn initial child: %w", err)
+	}
+	log.Printf("supervisor: started child pid %d", child.Process.Pid)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	for {
+		select {
+		case <-hup:
+			log.Println("supervisor: SIGHUP received, starting new child")
+			newChild, err := spawnChild(mainFile, redirectFile, metricsFile)
+			if err != nil {
+				log.Printf("supervisor: failed to start replacement child: %v", err)
+				continue
+			}
+			log.Printf("supervisor: new child pid %d ready, terminating old child pid %d", newChild.Process.Pid, child.Process.Pid)
+			_ = child.Process.Signal(syscall.SIGTERM)
+			_ = child.Wait()
+			child = newChild
+		case sig := <-quit:
+			log.Printf("supervisor: %s received, stopping child and exiting", sig)
+			_ = child.Process.Signal(syscall.SIGTERM)
+			_ = child.Wait()
+			return nil
+		}
+	}
+}
+
+// listenFile binds addr and returns the *os.File duplicate of its fd that
+// can be handed to a child via ExtraFiles, plus a func that closes both the
+// listener and the duplicate.
+func listenFile(network, addr string) (*os.File, func(), error) {
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listen %s: %w", addr, err)
+	}
+	tcpLn, ok := ln.(*net.TCPListener)
+	if !ok {
+		ln.Close()
+		return nil, nil, fmt.Errorf("expected *net.TCPListener for %s, got %T", addr, ln)
+	}
+	f, err := tcpLn.File()
+	if err != nil {
+		ln.Close()
+		return nil, nil, fmt.Errorf("dup listener fd for %s: %w", addr, err)
+	}
+	return f, func() {
+		f.Close()
+		ln.Close()
+	}, nil
+}
+
+// spawnChild execs the current binary as a child process, handing it the
+// shared listeners (main, and optionally redirect/metrics) plus a
+// readiness pipe via ExtraFiles, and blocks until the child signals
+// readiness on that pipe (or exits early with an error). The ExtraFiles
+// order here must match reloadFDLayout.
+func spawnChild(mainFile, redirectFile, metricsFile *os.File) (*exec.Cmd, error) {
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("create readiness pipe: %w", err)
+	}
+	defer readyW.Close()
+	defer readyR.Close()
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Env = append(os.Environ(), reloadEnvVar+"=1")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{mainFile, readyW}
+	if redirectFile != nil {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, redirectFile)
+	}
+	if metricsFile != nil {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, metricsFile)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start child: %w", err)
+	}
+
+	buf := make([]byte, 16)
+	n, err := readyR.Read(buf)
+	if err != nil || n == 0 {
+		return nil, fmt.Errorf("child did not signal readiness: %w", err)
+	}
+	return cmd, nil
+}
@@ -1,145 +1,436 @@
-package main
-
-import (
-	"context"
-	"flag"
-	"fmt"
-	"log"
-	"net/http"
-	"os"
-	"os/signal"
-	"path/filepath"
-	"syscall"
-	"time"
-)
-
-type loggingResponseWriter struct {
-	http.ResponseWriter
-	status int
-	size   int
-}
-
-func (w *loggingResponseWriter) WriteHeader(status int) {
-	w.status = status
-	w.ResponseWriter.WriteHeader(status)
-}
-
-func (w *loggingResponseWriter) Write(b []byte) (int, error) {
-	if w.status == 0 {
-		w.status = http.StatusOK
-	}
-	n, err := w.ResponseWriter.Write(b)
-	w.size += n
-	return n, err
-}
-
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		lw := &loggingResponseWriter{ResponseWriter: w}
-		next.ServeHTTP(lw, r)
-		if lw.status == 0 {
-			lw.status = http.StatusOK
-		}
-		log.Printf("%s %s %s %d %dB %s",
-			r.RemoteAddr, r.Method, r.URL.Path, lw.status, lw.size, time.Since(start))
-	})
-}
-
-func main() {
-	// Flags and env
-	var (
-		port      = flag.String("port", getEnv("PORT", "8080"), "server port")
-		publicDir = flag.String("public", "./public", "directory to serve static files from")
-	)
-	flag.Parse()
-
-	absPublic, err := filepath.Abs(*publicDir)
-	if err != nil {
-		log.Fatalf("failed to resolve public dir: %v", err)
-	}
-
-	mux := http.NewServeMux()
-
-	// Health check
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("ok"))
-	})
-
-	// Static files: serve files under /static/
-	fs := http.FileServer(http.Dir(absPublic))
-	mux.Handle("/static/", http.StripPrefix("/static/", fs))
-
-	// Root: try to serve index.html for SPA or fallback to 404
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// If requesting "/" or a file that doesn't exist, serve index.html (SPA fallback)
-		p := filepath.Clean(r.URL.Path)
-		if p == "/" {
-			http.ServeFile(w, r, filepath.Join(absPublic, "index.html"))
-			return
-		}
-		// Try to serve the file from public dir
-		fp := filepath.Join(absPublic, p)
-		if _, err := os.Stat(fp); err == nil {
-			http.ServeFile(w, r, fp)
-			return
-		}
-		// Not found: fallback to index.html for client routing
-		http.ServeFile(w, r, filepath.Join(absPublic, "index.html"))
-	})
-
-	handler := loggingMiddleware(mux)
-
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%s", *port),
-		Handler:      handler,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  120 * time.Second,
-	}
-
-	// Start server
-	done := make(chan struct{})
-	go func() {
-		log.Printf("starting server on %s (serving %s)", srv.Addr, absPublic)
-		// If TLS env vars present, run HTTPS
-		cert := os.Getenv("TLS_CERT")
-		key := os.Getenv("TLS_KEY")
-		var err error
-		if cert != "" && key != "" {
-			err = srv.ListenAndServeTLS(cert, key)
-		} else {
-			err = srv.ListenAndServe()
-		}
-		if err != nil && err != http.ErrServerClosed {
-			log.Fatalf("server error: %v", err)
-		}
-		close(done)
-	}()
-
-	// Graceful shutdown on SIGINT/SIGTERM
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	log.Println("shutdown signal received, shutting down server...")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("graceful shutdown failed: %v", err)
-		if err := srv.Close(); err != nil {
-			log.Printf("server close failed: %v", err)
-		}
-	}
-
-	<-done
-	log.Println("server stopped")
-}
-
-func getEnv(key, def string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
-	}
-	return def
-}
\ No newline at end of file
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+)
+
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *loggingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// Unwrap exposes the underlying ResponseWriter so http.NewResponseController
+// can reach through to optional interfaces (Hijacker, Flusher) that the
+// wrapped writer implements but loggingResponseWriter itself does not.
+func (w *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Push forwards to the underlying ResponseWriter's http.Pusher when it has
+// one, so PushAssets's w.(http.Pusher) assertion succeeds even though
+// loggingResponseWriter wraps it. ResponseController has no Push method, so
+// Hijacker/Flusher go through Unwrap but Pusher has to be forwarded here.
+func (w *loggingResponseWriter) Push(target string, opts *http.PushOptions) error {
+	pusher, ok := w.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return pusher.Push(target, opts)
+}
+
+func main() {
+	// Flags and env
+	var (
+		port           = flag.String("port", getEnv("PORT", "8080"), "server port")
+		publicDir      = flag.String("public", "./public", "directory to serve static files from")
+		autocertHosts  = flag.String("autocert-hosts", "", "comma-separated hostnames to request Let's Encrypt certificates for (enables autocert)")
+		autocertCache  = flag.String("autocert-cache", "./autocert-cache", "directory used to cache ACME account/certificate data")
+		httpRedirect   = flag.Bool("http-redirect", false, "bind :80 and redirect to the HTTPS server (also serves ACME http-01 challenges when autocert is enabled)")
+		logFormat      = flag.String("log-format", "text", "access log format: text or json")
+		metricsBind    = flag.String("metrics-bind", "", "bind address for /metrics (e.g. 127.0.0.1:9100); empty mounts /metrics on the main listener")
+		authBasicFile  = flag.String("auth-basic-file", "", "htpasswd-style file of user:bcrypt-or-argon2id-hash lines; enables HTTP Basic auth")
+		authBearerFile = flag.String("auth-bearer-file", "", "file of identity:token lines; enables bearer token auth")
+		authClientCA   = flag.String("auth-client-ca", "", "PEM file of CAs trusted to sign client certificates; enables mTLS auth")
+		authProtect    = flag.String("auth-protect", "", "comma-separated path prefixes to gate behind -auth-* (e.g. /admin/,/api/)")
+		h2c            = flag.Bool("h2c", false, "accept cleartext HTTP/2 (h2c), for use behind a load balancer that already terminates TLS")
+		h2MaxStreams   = flag.Uint("h2-max-concurrent-streams", 250, "HTTP/2 MaxConcurrentStreams per connection")
+		h2MaxReadFrame = flag.Uint("h2-max-read-frame-size", 0, "HTTP/2 MaxReadFrameSize in bytes (0 = library default)")
+		h2IdleTimeout  = flag.Duration("h2-idle-timeout", 120*time.Second, "HTTP/2 connection idle timeout")
+		reload         = flag.Bool("reload", false, "enable zero-downtime reload: fork a supervisor that hands off the listening socket to a fresh child on SIGHUP")
+	)
+	var proxies proxyFlags
+	flag.Var(&proxies, "proxy", "reverse-proxy mapping /prefix/=http://host:port[,strip-prefix=bool,preserve-host=bool,timeout=dur] (repeatable)")
+	flag.Parse()
+
+	if *reload && !isReloadChild() {
+		if err := runSupervisor(fmt.Sprintf(":%s", *port), *httpRedirect, *metricsBind); err != nil {
+			log.Fatalf("supervisor error: %v", err)
+		}
+		return
+	}
+
+	var proxyRoutes []proxyRoute
+	for _, raw := range proxies {
+		route, err := parseProxyRoute(raw)
+		if err != nil {
+			log.Fatalf("invalid -proxy flag: %v", err)
+		}
+		proxyRoutes = append(proxyRoutes, route)
+	}
+
+	absPublic, err := filepath.Abs(*publicDir)
+	if err != nil {
+		log.Fatalf("failed to resolve public dir: %v", err)
+	}
+
+	var authers []Auther
+	if *authBasicFile != "" {
+		a, err := newBasicAuther(*authBasicFile)
+		if err != nil {
+			log.Fatalf("failed to load -auth-basic-file: %v", err)
+		}
+		authers = append(authers, a)
+	}
+	if *authBearerFile != "" {
+		a, err := newBearerAuther(*authBearerFile)
+		if err != nil {
+			log.Fatalf("failed to load -auth-bearer-file: %v", err)
+		}
+		authers = append(authers, a)
+	}
+	var clientCAs *x509.CertPool
+	if *authClientCA != "" {
+		clientCAs, err = loadClientCAPool(*authClientCA)
+		if err != nil {
+			log.Fatalf("failed to load -auth-client-ca: %v", err)
+		}
+		authers = append(authers, mtlsAuther{})
+	}
+	var authProtectPrefixes []string
+	if *authProtect != "" {
+		authProtectPrefixes = strings.Split(*authProtect, ",")
+	}
+
+	proxyPrefixes := make([]string, 0, len(proxyRoutes))
+	for _, route := range proxyRoutes {
+		proxyPrefixes = append(proxyPrefixes, route.prefix)
+	}
+
+	mux := http.NewServeMux()
+
+	// Health check
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	// Static files: serve files under /static/
+	fs := http.FileServer(http.Dir(absPublic))
+	mux.Handle("/static/", http.StripPrefix("/static/", fs))
+
+	// Reverse-proxied backends, mounted before the SPA catch-all so their
+	// prefixes take precedence over it.
+	registerProxyRoutes(mux, proxyRoutes)
+
+	// /metrics stays on the main listener unless -metrics-bind moves it to
+	// its own address (e.g. loopback), which is the safer default for a
+	// public-facing edge server.
+	if *metricsBind == "" {
+		mux.Handle("/metrics", promhttp.Handler())
+	}
+
+	// Root: try to serve index.html for SPA or fallback to 404
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// If requesting "/" or a file that doesn't exist, serve index.html (SPA fallback)
+		p := filepath.Clean(r.URL.Path)
+		if p == "/" {
+			PushAssets(w, r, []string{"/static/app.js", "/static/app.css"})
+			http.ServeFile(w, r, filepath.Join(absPublic, "index.html"))
+			return
+		}
+		// Try to serve the file from public dir
+		fp := filepath.Join(absPublic, p)
+		if _, err := os.Stat(fp); err == nil {
+			http.ServeFile(w, r, fp)
+			return
+		}
+		// Not found: fallback to index.html for client routing
+		http.ServeFile(w, r, filepath.Join(absPublic, "index.html"))
+	})
+
+	var handler http.Handler = mux
+	if len(authers) > 0 {
+		handler = authMiddleware(handler, authers, authProtectPrefixes)
+	}
+	handler = instrumentedLoggingMiddleware(handler, *logFormat, proxyPrefixes)
+
+	h2s := &http2.Server{
+		MaxConcurrentStreams: uint32(*h2MaxStreams),
+		MaxReadFrameSize:     uint32(*h2MaxReadFrame),
+		IdleTimeout:          *h2IdleTimeout,
+	}
+	if *h2c {
+		handler = h2cHandler(handler, h2s)
+	}
+
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%s", *port),
+		Handler:      handler,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+
+	// Autocert obtains and renews Let's Encrypt certificates automatically
+	// when -autocert-hosts is set, sparing small SPA deployments the need
+	// for an external cert-management sidecar. The ACME CA dials back in on
+	// the well-known HTTPS port (443) to complete the TLS-ALPN-01 challenge,
+	// so -port must be 443 for issuance to have any chance of succeeding.
+	var certManager *autocert.Manager
+	if *autocertHosts != "" {
+		if *port != "443" {
+			log.Fatalf("-autocert-hosts requires -port=443 (got %q): the ACME CA validates the TLS-ALPN-01 challenge by dialing the host on 443", *port)
+		}
+		hosts := strings.Split(*autocertHosts, ",")
+		for i := range hosts {
+			hosts[i] = strings.TrimSpace(hosts[i])
+		}
+		certManager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(hosts...),
+			Cache:      autocert.DirCache(*autocertCache),
+		}
+		srv.TLSConfig = certManager.TLSConfig()
+	}
+
+	// mTLS client-certificate verification, layered onto whichever
+	// TLSConfig autocert (or the default) already produced. We only ask
+	// for a client cert here, not require one: /healthz and /static/ must
+	// stay reachable without a cert, ACME's TLS-ALPN-01 challenge
+	// connections present none at all, and mtlsAuther.Authenticate is what
+	// actually enforces presence for -auth-protect prefixes.
+	if clientCAs != nil {
+		if srv.TLSConfig == nil {
+			srv.TLSConfig = &tls.Config{}
+		}
+		srv.TLSConfig.ClientCAs = clientCAs
+		srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	// Explicitly configure HTTP/2 (instead of relying on the implicit
+	// negotiation ListenAndServeTLS does on our behalf) so the tuning
+	// flags above actually take effect.
+	tlsEnabled := certManager != nil || (os.Getenv("TLS_CERT") != "" && os.Getenv("TLS_KEY") != "")
+	if tlsEnabled {
+		if err := http2.ConfigureServer(srv, h2s); err != nil {
+			log.Fatalf("failed to configure HTTP/2: %v", err)
+		}
+	}
+
+	// The redirect server binds :80. With autocert enabled it also answers
+	// ACME http-01 challenges; otherwise it just 301s everything to HTTPS.
+	var redirectSrv *http.Server
+	if *httpRedirect {
+		var redirectHandler http.Handler
+		if certManager != nil {
+			redirectHandler = certManager.HTTPHandler(nil)
+		} else {
+			redirectHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				target := httpsRedirectTarget(r.Host, *port, r.URL.RequestURI())
+				http.Redirect(w, r, target, http.StatusMovedPermanently)
+			})
+		}
+		redirectSrv = &http.Server{
+			Addr:    ":80",
+			Handler: redirectHandler,
+		}
+	}
+
+	// metricsSrv only runs when -metrics-bind is set, giving /metrics its
+	// own address instead of sharing the public listener.
+	var metricsSrv *http.Server
+	if *metricsBind != "" {
+		metricsSrv = newMetricsServer(*metricsBind)
+	}
+
+	// Under -reload this process is a child handed its listeners by
+	// runSupervisor instead of binding its own, so it can keep serving
+	// in-flight connections while a replacement child takes over new ones.
+	// redirectFD/metricsFD are computed the same way on both sides of the
+	// fork, from the same -http-redirect/-metrics-bind flag values.
+	var ln net.Listener
+	redirectFD, metricsFD := reloadFDLayout(*httpRedirect, *metricsBind)
+	if isReloadChild() {
+		l, err := inheritedListener()
+		if err != nil {
+			log.Fatalf("failed to use inherited listener: %v", err)
+		}
+		ln = l
+	}
+
+	// Start server(s)
+	done := make(chan struct{})
+	go func() {
+		log.Printf("starting server on %s (serving %s)", srv.Addr, absPublic)
+		if ln != nil {
+			signalReady()
+		}
+		var err error
+		switch {
+		case certManager != nil:
+			if ln != nil {
+				err = srv.ServeTLS(ln, "", "")
+			} else {
+				err = srv.ListenAndServeTLS("", "")
+			}
+		default:
+			// If TLS env vars present, run HTTPS
+			cert := os.Getenv("TLS_CERT")
+			key := os.Getenv("TLS_KEY")
+			switch {
+			case cert != "" && key != "":
+				if ln != nil {
+					err = srv.ServeTLS(ln, cert, key)
+				} else {
+					err = srv.ListenAndServeTLS(cert, key)
+				}
+			default:
+				if ln != nil {
+					err = srv.Serve(ln)
+				} else {
+					err = srv.ListenAndServe()
+				}
+			}
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("server error: %v", err)
+		}
+		close(done)
+	}()
+
+	redirectDone := make(chan struct{})
+	if redirectSrv != nil {
+		go func() {
+			log.Printf("starting redirect server on %s", redirectSrv.Addr)
+			var err error
+			if isReloadChild() {
+				l, lErr := inheritedListenerAt(redirectFD)
+				if lErr != nil {
+					log.Fatalf("failed to use inherited redirect listener: %v", lErr)
+				}
+				err = redirectSrv.Serve(l)
+			} else {
+				err = redirectSrv.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatalf("redirect server error: %v", err)
+			}
+			close(redirectDone)
+		}()
+	} else {
+		close(redirectDone)
+	}
+
+	metricsDone := make(chan struct{})
+	if metricsSrv != nil {
+		go func() {
+			log.Printf("starting metrics server on %s", metricsSrv.Addr)
+			var err error
+			if isReloadChild() {
+				l, lErr := inheritedListenerAt(metricsFD)
+				if lErr != nil {
+					log.Fatalf("failed to use inherited metrics listener: %v", lErr)
+				}
+				err = metricsSrv.Serve(l)
+			} else {
+				err = metricsSrv.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatalf("metrics server error: %v", err)
+			}
+			close(metricsDone)
+		}()
+	} else {
+		close(metricsDone)
+	}
+
+	// Graceful shutdown on SIGINT/SIGTERM
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("shutdown signal received, shutting down server...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("graceful shutdown failed: %v", err)
+		if err := srv.Close(); err != nil {
+			log.Printf("server close failed: %v", err)
+		}
+	}
+	if redirectSrv != nil {
+		if err := redirectSrv.Shutdown(ctx); err != nil {
+			log.Printf("redirect server graceful shutdown failed: %v", err)
+			if err := redirectSrv.Close(); err != nil {
+				log.Printf("redirect server close failed: %v", err)
+			}
+		}
+	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			log.Printf("metrics server graceful shutdown failed: %v", err)
+			if err := metricsSrv.Close(); err != nil {
+				log.Printf("metrics server close failed: %v", err)
+			}
+		}
+	}
+
+	<-done
+	<-redirectDone
+	<-metricsDone
+	log.Println("server stopped")
+}
+
+// httpsRedirectTarget builds the HTTPS URL the :80 redirect server sends
+// clients to. host is the request's Host header (the port in it, if any,
+// is the :80 redirect listener's own port and must be discarded); httpsPort
+// is -port, the port the HTTPS server actually listens on, which only needs
+// stating explicitly when it isn't the default HTTPS port 443.
+func httpsRedirectTarget(host, httpsPort, path string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if httpsPort != "" && httpsPort != "443" {
+		host = net.JoinHostPort(host, httpsPort)
+	}
+	return "https://" + host + path
+}
+
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestHTTPSRedirectTarget(t *testing.T) {
+	tests := []struct {
+		name      string
+		host      string
+		httpsPort string
+		path      string
+		want      string
+	}{
+		{name: "default https port omitted", host: "example.com", httpsPort: "443", path: "/", want: "https://example.com/"},
+		{name: "non-default port appended", host: "example.com", httpsPort: "8443", path: "/widgets", want: "https://example.com:8443/widgets"},
+		{name: "redirect listener port in host is discarded", host: "example.com:80", httpsPort: "443", path: "/", want: "https://example.com/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := httpsRedirectTarget(tt.host, tt.httpsPort, tt.path); got != tt.want {
+				t.Errorf("httpsRedirectTarget(%q, %q, %q) = %q, want %q", tt.host, tt.httpsPort, tt.path, got, tt.want)
+			}
+		})
+	}
+}